@@ -0,0 +1,164 @@
+package mailer
+
+// HealthCheck verifies that a single sending profile's SMTP path is still
+// usable, independent of actually sending a campaign. It checks one profile
+// per call; running it across several profiles and comparing their reports
+// is left to the caller, and no admin endpoint or CLI command calls it.
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// probedExtensions is the set of EHLO extensions HealthCheck records.
+var probedExtensions = []string{"STARTTLS", "AUTH", "8BITMIME", "SIZE", "PIPELINING", "SMTPUTF8"}
+
+// HealthProbe configures the optional, more invasive checks HealthCheck can
+// perform against a sending profile.
+type HealthProbe struct {
+	// Auth, if set, is attempted after STARTTLS (or immediately, if the
+	// server doesn't advertise STARTTLS).
+	Auth smtp.Auth
+	// From and To, if both set, are used to run a MAIL FROM/RCPT TO/RSET
+	// probe without actually sending a message.
+	From string
+	To   string
+	// TLSConfig overrides the tls.Config used for STARTTLS. If nil, a
+	// config with ServerName set to the profile's host is used.
+	TLSConfig *tls.Config
+}
+
+// HealthReport is the result of a single HealthCheck call.
+type HealthReport struct {
+	Host      string
+	CheckedAt time.Time
+	Latency   time.Duration
+
+	// Extensions records which of probedExtensions the server advertised;
+	// other EHLO extensions are not recorded.
+	Extensions map[string]bool
+
+	STARTTLSUsed  bool
+	STARTTLSError string
+	TLSVersion    string
+	TLSCertChain  []string
+	TLSCertExpiry time.Time
+
+	AuthOK    bool
+	AuthError string
+
+	ProbeOK    bool
+	ProbeError string
+
+	// Error is set when the check failed before we could even negotiate
+	// EHLO - i.e. the profile is not usable at all right now.
+	Error string
+}
+
+// HealthCheck dials dialer.Host(), performs EHLO, STARTTLS (if advertised),
+// and the optional AUTH/MAIL-RCPT-RSET checks in probe, and returns a
+// report describing what it found. It returns a non-nil error only when
+// the connection couldn't be established or EHLO failed; every other
+// failure is recorded on the returned HealthReport so callers can diff
+// reports across profiles instead of failing outright.
+func HealthCheck(ctx context.Context, dialer Dialer, probe HealthProbe) (*HealthReport, error) {
+	report := &HealthReport{
+		Host:      dialer.Host(),
+		CheckedAt: time.Now(),
+	}
+	start := time.Now()
+
+	netDialer := &net.Dialer{Timeout: dialer.SMTPDialTimeout()}
+	conn, err := netDialer.DialContext(ctx, "tcp", dialer.Host())
+	if err != nil {
+		report.Error = err.Error()
+		return report, err
+	}
+	defer conn.Close()
+
+	host, _, err := net.SplitHostPort(dialer.Host())
+	if err != nil {
+		host = dialer.Host()
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		report.Error = err.Error()
+		return report, err
+	}
+	defer client.Close()
+
+	if err := client.Hello("localhost"); err != nil {
+		report.Error = err.Error()
+		return report, err
+	}
+
+	report.Extensions = make(map[string]bool, len(probedExtensions))
+	for _, ext := range probedExtensions {
+		if ok, _ := client.Extension(ext); ok {
+			report.Extensions[ext] = true
+		}
+	}
+
+	if report.Extensions["STARTTLS"] {
+		tlsConfig := probe.TLSConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{ServerName: host}
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			report.STARTTLSError = err.Error()
+		} else {
+			report.STARTTLSUsed = true
+			if state, ok := client.TLSConnectionState(); ok {
+				report.TLSVersion = tlsVersionName(state.Version)
+				for _, cert := range state.PeerCertificates {
+					report.TLSCertChain = append(report.TLSCertChain, cert.Subject.CommonName)
+				}
+				if len(state.PeerCertificates) > 0 {
+					report.TLSCertExpiry = state.PeerCertificates[0].NotAfter
+				}
+			}
+		}
+	}
+
+	if probe.Auth != nil {
+		if err := client.Auth(probe.Auth); err != nil {
+			report.AuthError = err.Error()
+		} else {
+			report.AuthOK = true
+		}
+	}
+
+	if probe.From != "" && probe.To != "" {
+		if err := client.Mail(probe.From); err != nil {
+			report.ProbeError = err.Error()
+		} else if err := client.Rcpt(probe.To); err != nil {
+			report.ProbeError = err.Error()
+		} else {
+			report.ProbeOK = true
+		}
+		client.Reset()
+	}
+
+	client.Quit()
+	report.Latency = time.Since(start)
+	return report, nil
+}
+
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	default:
+		return "unknown"
+	}
+}
@@ -2,6 +2,7 @@ package mailer
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/textproto"
@@ -15,6 +16,17 @@ import (
 // MaxReconnectAttempts is the maximum number of times we should reconnect to a server
 var MaxReconnectAttempts = 10
 
+// DefaultPerMessageTimeout is the watchdog timeout NewMailBundle assigns to
+// MailBundle.PerMessageTimeout. It bounds how long a single gomail.Send call
+// is allowed to block before we assume the connection is wedged.
+const DefaultPerMessageTimeout = 60 * time.Second
+
+// errSendTimeout is returned internally when a gomail.Send call doesn't
+// complete within PerMessageTimeout. It's never passed to Mail.Error; the
+// message is instead handed to Mail.Backoff with it, since the underlying
+// connection - not the message - is presumed to be at fault.
+var errSendTimeout = errors.New("mailer: smtp send timed out")
+
 // ErrMaxConnectAttempts is thrown when the maximum number of reconnect attempts
 // is reached.
 type ErrMaxConnectAttempts struct {
@@ -40,6 +52,18 @@ type Sender interface {
 // Dialer dials to an SMTP server and returns the SendCloser
 type Dialer interface {
 	Dial() (Sender, error)
+
+	// SMTPDialTimeout reports the deadline a concrete Dialer wants applied
+	// to the initial connection. The Sender returned by Dial doesn't expose
+	// its net.Conn, so sendMail can't enforce a read/write deadline itself
+	// and relies on its own per-message watchdog instead; HealthCheck,
+	// which dials directly, is what actually enforces this today.
+	SMTPDialTimeout() time.Duration
+
+	// Host returns the "host:port" this Dialer connects to, so callers
+	// (e.g. HealthCheck) that need a raw SMTP connection rather than a
+	// Sender can dial it directly.
+	Host() string
 }
 
 // Mail is an interface that handles the common operations for email messages
@@ -55,13 +79,20 @@ type Mail interface {
 type MailBundle struct {
 	Delay int64
 	Mails []Mail
+
+	// PerMessageTimeout bounds how long a single gomail.Send call may
+	// block. If it's exceeded, the connection is forcibly closed, the
+	// message is backed off, and sending continues with a fresh
+	// connection. Defaults to DefaultPerMessageTimeout.
+	PerMessageTimeout time.Duration
 }
 
 // NewMailBundle returns an instance of MailBundle
 func NewMailBundle(ms []Mail, delay int64) *MailBundle {
 	return &MailBundle{
-		Mails: ms,
-		Delay: delay,
+		Mails:             ms,
+		Delay:             delay,
+		PerMessageTimeout: DefaultPerMessageTimeout,
 	}
 }
 
@@ -105,11 +136,15 @@ func (mw *MailWorker) Start(ctx context.Context) {
 					errorMail(err, ms)
 					return
 				}
+				timeout := mb.PerMessageTimeout
+				if timeout <= 0 {
+					timeout = DefaultPerMessageTimeout
+				}
 				// Check for Delay
 				if mb.Delay <= 0 {
-					sendMail(ctx, dialer, ms)
+					sendMail(ctx, dialer, ms, timeout)
 				} else {
-					sendDelayedMail(ctx, dialer, ms, mb.Delay)
+					sendDelayedMail(ctx, dialer, ms, mb.Delay, timeout)
 				}
 			}(ctx, mb)
 		}
@@ -153,10 +188,30 @@ func dialHost(ctx context.Context, dialer Dialer) (Sender, error) {
 	return sender, err
 }
 
+// sendWithWatchdog runs gomail.Send in a child goroutine and returns
+// errSendTimeout if it doesn't complete within timeout, so a half-open
+// connection or a server that never ACKs can't block the worker forever.
+// The child goroutine's result is discarded if it arrives after the
+// timeout - whoever observes the timeout is responsible for closing the
+// connection so that stray goroutine's eventual write fails instead of
+// corrupting a connection we've already handed to someone else.
+func sendWithWatchdog(sender Sender, message *gomail.Message, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- gomail.Send(sender, message)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return errSendTimeout
+	}
+}
+
 // sendMail attempts to send the provided Mail instances.
 // If the context is cancelled before all of the mail are sent,
 // sendMail just returns and does not modify those emails.
-func sendMail(ctx context.Context, dialer Dialer, ms []Mail) {
+func sendMail(ctx context.Context, dialer Dialer, ms []Mail, timeout time.Duration) {
 	sender, err := dialHost(ctx, dialer)
 	if err != nil {
 		log.Warn(err)
@@ -164,7 +219,6 @@ func sendMail(ctx context.Context, dialer Dialer, ms []Mail) {
 		return
 	}
 	defer sender.Close()
-	message := gomail.NewMessage()
 	for i, m := range ms {
 		select {
 		case <-ctx.Done():
@@ -172,15 +226,35 @@ func sendMail(ctx context.Context, dialer Dialer, ms []Mail) {
 		default:
 			break
 		}
-		message.Reset()
+		// A fresh Message per iteration, not a shared one reset in place:
+		// if sendWithWatchdog times out, its child goroutine may still be
+		// reading the message after we've moved on, and reusing the same
+		// pointer would race that read against the next iteration's writes.
+		message := gomail.NewMessage()
 		err = m.Generate(message)
 		if err != nil {
 			log.Warn(err)
 			m.Error(err)
 			continue
 		}
-		err = gomail.Send(sender, message)
+		err = sendWithWatchdog(sender, message, timeout)
 		if err != nil {
+			if err == errSendTimeout {
+				// The connection is presumed wedged, not the message, so we
+				// backoff rather than error, close out the stalled
+				// connection, and redial before moving on.
+				log.WithFields(logrus.Fields{
+					"email": message.GetHeader("To")[0],
+				}).Warn(err)
+				m.Backoff(err)
+				sender.Close()
+				sender, err = dialHost(ctx, dialer)
+				if err != nil {
+					errorMail(err, ms[i+1:])
+					break
+				}
+				continue
+			}
 			if te, ok := err.(*textproto.Error); ok {
 				switch {
 				// If it's a temporary error, we should backoff and try again later.
@@ -241,7 +315,7 @@ func sendMail(ctx context.Context, dialer Dialer, ms []Mail) {
 }
 
 //
-func sendDelayedMail(ctx context.Context, dialer Dialer, ms []Mail, delay int64) {
+func sendDelayedMail(ctx context.Context, dialer Dialer, ms []Mail, delay int64, timeout time.Duration) {
 	interval := time.Duration(delay) * time.Second
 	tick := time.NewTicker(interval).C
 	for i, m := range ms {
@@ -251,7 +325,7 @@ func sendDelayedMail(ctx context.Context, dialer Dialer, ms []Mail, delay int64)
 			return
 		case <-tick:
 			log.Infof("Sending Mail ( %d / %d )", i+1, len(ms))
-			sendMail(ctx, dialer, []Mail{m})
+			sendMail(ctx, dialer, []Mail{m}, timeout)
 		}
 	}
 	log.Info("Mailer Finished")
@@ -0,0 +1,146 @@
+package mailer
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gophish/gomail"
+)
+
+// scriptedSender wraps a raw TCP connection so Send can stall exactly like
+// a half-open SMTP connection would: it writes the message, then blocks
+// reading a response that (for the first connection in these tests) never
+// arrives.
+type scriptedSender struct {
+	conn net.Conn
+}
+
+func (s *scriptedSender) Send(from string, to []string, msg io.WriterTo) error {
+	if _, err := msg.WriteTo(s.conn); err != nil {
+		return err
+	}
+	buf := make([]byte, 1)
+	_, err := s.conn.Read(buf)
+	return err
+}
+
+func (s *scriptedSender) Close() error { return s.conn.Close() }
+func (s *scriptedSender) Reset() error { return nil }
+
+// scriptedDialer dials a fresh connection to addr on every call, so the
+// test can observe a redial after a watchdog timeout.
+type scriptedDialer struct {
+	addr  string
+	dials int
+}
+
+func (d *scriptedDialer) Dial() (Sender, error) {
+	conn, err := net.Dial("tcp", d.addr)
+	if err != nil {
+		return nil, err
+	}
+	d.dials++
+	return &scriptedSender{conn: conn}, nil
+}
+
+func (d *scriptedDialer) SMTPDialTimeout() time.Duration { return 0 }
+func (d *scriptedDialer) Host() string                   { return d.addr }
+
+// idleReadTimeout is how long readUntilIdle waits for the next byte before
+// deciding a message is fully written. It must stay well under the test's
+// watchdog timeout, so the "recovered" connection's reply always lands
+// before the client gives up on it.
+const idleReadTimeout = 15 * time.Millisecond
+
+// readUntilIdle drains conn until a read doesn't complete within
+// idleReadTimeout. scriptedSender never closes its write side between
+// messages, so there's no EOF to read to; a short idle gap after the last
+// byte is the only signal that the message is finished.
+func readUntilIdle(conn net.Conn) {
+	buf := make([]byte, 4096)
+	for {
+		conn.SetReadDeadline(time.Now().Add(idleReadTimeout))
+		if _, err := conn.Read(buf); err != nil {
+			return
+		}
+	}
+}
+
+// stallThenRecoverListener accepts exactly two connections: the first is
+// read and then left open with no reply (simulating a server that accepts
+// DATA but never sends the final 250), the second is read and immediately
+// acked so the retried send succeeds.
+func stallThenRecoverListener(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		stalled, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		readUntilIdle(stalled)
+		// Deliberately never write a response; wait for the test to close us.
+
+		recovered, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer recovered.Close()
+		readUntilIdle(recovered)
+		recovered.Write([]byte{'1'})
+	}()
+
+	return ln.Addr().String()
+}
+
+type fakeMail struct {
+	to        string
+	backedOff bool
+	succeeded bool
+	errored   error
+}
+
+func (m *fakeMail) Backoff(err error) error { m.backedOff = true; return nil }
+func (m *fakeMail) Error(err error) error   { m.errored = err; return nil }
+func (m *fakeMail) Success() error          { m.succeeded = true; return nil }
+func (m *fakeMail) Generate(msg *gomail.Message) error {
+	msg.SetHeader("From", "sender@example.com")
+	msg.SetHeader("To", m.to)
+	msg.SetBody("text/plain", "hello")
+	return nil
+}
+func (m *fakeMail) GetDialer() (Dialer, error) { return nil, nil }
+
+// TestSendMailRecoversFromWatchdogTimeout proves that a message stuck on a
+// half-open connection is backed off (not errored), and that sendMail
+// redials and successfully delivers the remaining messages.
+func TestSendMailRecoversFromWatchdogTimeout(t *testing.T) {
+	addr := stallThenRecoverListener(t)
+	dialer := &scriptedDialer{addr: addr}
+
+	stalled := &fakeMail{to: "stalled@example.com"}
+	recovered := &fakeMail{to: "recovered@example.com"}
+
+	sendMail(context.Background(), dialer, []Mail{stalled, recovered}, 100*time.Millisecond)
+
+	if !stalled.backedOff {
+		t.Errorf("expected the stalled message to be backed off, not errored/succeeded")
+	}
+	if stalled.succeeded || stalled.errored != nil {
+		t.Errorf("stalled message should only be backed off, got succeeded=%v errored=%v", stalled.succeeded, stalled.errored)
+	}
+	if !recovered.succeeded {
+		t.Errorf("expected the second message to succeed after redialing, got errored=%v backedOff=%v", recovered.errored, recovered.backedOff)
+	}
+	if dialer.dials != 2 {
+		t.Errorf("expected 2 dials (initial + redial after timeout), got %d", dialer.dials)
+	}
+}
@@ -0,0 +1,135 @@
+package webhook
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Format selects the wire format DefaultSender uses for outgoing
+// deliveries.
+type Format int
+
+const (
+	// FormatLegacy sends the ad-hoc gophish JSON envelope (just the data,
+	// unwrapped). This is the default.
+	FormatLegacy Format = iota
+	// FormatCloudEventsStructured wraps the payload in a CloudEvents 1.0
+	// structured-mode JSON envelope, per
+	// https://github.com/cloudevents/spec/blob/v1.0/json-format.md.
+	FormatCloudEventsStructured
+	// FormatCloudEventsBinary sends the payload as-is and hoists the
+	// CloudEvents attributes to ce-* headers instead.
+	FormatCloudEventsBinary
+)
+
+const cloudEventsSpecVersion = "1.0"
+
+// DefaultCloudEventType is used when data doesn't implement
+// CloudEventMetadata.
+const DefaultCloudEventType = "com.gophish.webhook.delivery"
+
+// CloudEventMetadata lets a payload supply the CloudEvents `type` and
+// `subject` attributes, e.g. "com.gophish.campaign.email_sent" and a
+// campaign ID or result RID. Payloads that don't implement it fall back to
+// DefaultCloudEventType with an empty subject.
+type CloudEventMetadata interface {
+	CloudEventType() string
+	CloudEventSubject() string
+}
+
+// cloudEvent is the structured-mode JSON representation of a CloudEvents
+// 1.0 event.
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Time            string          `json:"time"`
+	Subject         string          `json:"subject,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// buildCloudEvent wraps data in a CloudEvents envelope, using ds.source as
+// the `source` attribute and the delivery ID (also used for the
+// X-Gophish-Delivery header) as the event ID.
+func (ds DefaultSender) buildCloudEvent(deliveryID string, data interface{}) (*cloudEvent, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	evtType := DefaultCloudEventType
+	subject := ""
+	if meta, ok := data.(CloudEventMetadata); ok {
+		evtType = meta.CloudEventType()
+		subject = meta.CloudEventSubject()
+	}
+	return &cloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		ID:              deliveryID,
+		Source:          ds.source,
+		Type:            evtType,
+		Time:            time.Now().UTC().Format(time.RFC3339),
+		Subject:         subject,
+		DataContentType: "application/json",
+		Data:            payload,
+	}, nil
+}
+
+// deadLetterCloudEvent re-wraps a DLQ-replayed payload so a redrive under
+// FormatCloudEventsStructured/Binary can still satisfy CloudEventMetadata.
+// drainDeadLetters only has the marshaled Payload bytes to work with - a
+// bare json.RawMessage can't carry the original type/subject - so it
+// reattaches the values persisted on the DeadLetterEntry itself.
+type deadLetterCloudEvent struct {
+	raw     json.RawMessage
+	evtType string
+	subject string
+}
+
+func (e deadLetterCloudEvent) MarshalJSON() ([]byte, error) { return e.raw, nil }
+func (e deadLetterCloudEvent) CloudEventType() string       { return e.evtType }
+func (e deadLetterCloudEvent) CloudEventSubject() string    { return e.subject }
+
+// buildBody returns the HTTP body, Content-Type, and any ce-* headers to
+// send for the given delivery, depending on ds.format.
+func (ds DefaultSender) buildBody(deliveryID string, data interface{}) ([]byte, string, map[string]string, error) {
+	switch ds.format {
+	case FormatCloudEventsStructured:
+		evt, err := ds.buildCloudEvent(deliveryID, data)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		body, err := json.Marshal(evt)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		return body, "application/cloudevents+json", nil, nil
+	case FormatCloudEventsBinary:
+		body, err := json.Marshal(data)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		evt, err := ds.buildCloudEvent(deliveryID, data)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		headers := map[string]string{
+			"ce-specversion": evt.SpecVersion,
+			"ce-id":          evt.ID,
+			"ce-source":      evt.Source,
+			"ce-type":        evt.Type,
+			"ce-time":        evt.Time,
+		}
+		if evt.Subject != "" {
+			headers["ce-subject"] = evt.Subject
+		}
+		return body, "application/json", headers, nil
+	default:
+		body, err := json.Marshal(data)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		return body, "application/json", nil, nil
+	}
+}
@@ -0,0 +1,199 @@
+package webhook
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/gophish/gophish/logger"
+)
+
+// DefaultDLQFilename is the name of the JSONL file DLQ entries are written
+// to, relative to the gophish data directory.
+const DefaultDLQFilename = "webhook_dlq.jsonl"
+
+// DeadLetterEntry represents a webhook delivery that failed and was given
+// up on, either after exhausting its retry budget or after a failure the
+// retry policy does not retry.
+type DeadLetterEntry struct {
+	ID             string      `json:"id"`
+	URL            string      `json:"url"`
+	Secrets        []string    `json:"secrets"`
+	Payload        []byte      `json:"payload"`
+	Headers        http.Header `json:"headers"`
+	LastError      string      `json:"last_error"`
+	Attempts       int         `json:"attempts"`
+	NextEligibleAt time.Time   `json:"next_eligible_at"`
+
+	// CloudEventType and CloudEventSubject preserve the resolved CloudEvents
+	// `type`/`subject` attributes for deliveries sent under
+	// FormatCloudEventsStructured/Binary. They're empty for FormatLegacy
+	// deliveries. Without these, a redrive would replay Payload as a bare
+	// json.RawMessage, which can no longer satisfy CloudEventMetadata, and
+	// the type/subject would silently revert to the defaults.
+	CloudEventType    string `json:"cloud_event_type,omitempty"`
+	CloudEventSubject string `json:"cloud_event_subject,omitempty"`
+}
+
+// DeadLetterStore persists deliveries that failed and were given up on so
+// they can be inspected or re-drained later.
+type DeadLetterStore interface {
+	// Put records a failed delivery, along with the webhook URL it was for.
+	// An entry with the same ID as a stored one replaces it.
+	Put(entry DeadLetterEntry) error
+	// Due returns the entries eligible for re-delivery as of now.
+	Due(now time.Time) ([]DeadLetterEntry, error)
+	// Remove deletes an entry, typically after a successful re-drain.
+	Remove(id string) error
+	// Len reports how many entries are currently stored.
+	Len() (int, error)
+}
+
+// FileDeadLetterStore is the default DeadLetterStore. It keeps entries in an
+// on-disk JSONL file under the gophish data dir, rewriting the whole file
+// (through a temporary file and a rename) on every Put and Remove.
+type FileDeadLetterStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileDeadLetterStore returns a FileDeadLetterStore backed by the JSONL
+// file at path, creating the parent directory if necessary.
+func NewFileDeadLetterStore(path string) (*FileDeadLetterStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, err
+	}
+	return &FileDeadLetterStore{path: path}, nil
+}
+
+func (s *FileDeadLetterStore) readAll() ([]DeadLetterEntry, error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []DeadLetterEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var entry DeadLetterEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			log.Error(fmt.Errorf("webhook: skipping malformed DLQ entry: %w", err))
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func (s *FileDeadLetterStore) writeAll(entries []DeadLetterEntry) error {
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, entry := range entries {
+		b, err := json.Marshal(entry)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := w.Write(append(b, '\n')); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Put is an upsert keyed by entry.ID: it replaces any existing entry with
+// the same ID in place, and appends otherwise. This matters because
+// drainDeadLetters re-Puts the same entry (with an updated NextEligibleAt)
+// after a failed re-drain; a blind append would leave the stale copy in
+// the file forever, since Due matches every line whose NextEligibleAt has
+// passed.
+func (s *FileDeadLetterStore) Put(entry DeadLetterEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.readAll()
+	if err != nil {
+		return err
+	}
+	for i, existing := range entries {
+		if existing.ID == entry.ID {
+			entries[i] = entry
+			return s.writeAll(entries)
+		}
+	}
+	return s.writeAll(append(entries, entry))
+}
+
+// Due returns the entries whose NextEligibleAt has passed.
+func (s *FileDeadLetterStore) Due(now time.Time) ([]DeadLetterEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.readAll()
+	if err != nil {
+		return nil, err
+	}
+	var due []DeadLetterEntry
+	for _, entry := range entries {
+		if !entry.NextEligibleAt.After(now) {
+			due = append(due, entry)
+		}
+	}
+	return due, nil
+}
+
+// Remove deletes the entry with the given ID from the DLQ file.
+func (s *FileDeadLetterStore) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.readAll()
+	if err != nil {
+		return err
+	}
+	kept := entries[:0]
+	for _, entry := range entries {
+		if entry.ID != id {
+			kept = append(kept, entry)
+		}
+	}
+	return s.writeAll(kept)
+}
+
+// Len reports the number of entries currently in the DLQ file.
+func (s *FileDeadLetterStore) Len() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.readAll()
+	if err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
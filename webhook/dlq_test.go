@@ -0,0 +1,58 @@
+package webhook
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileDeadLetterStorePutUpserts(t *testing.T) {
+	store, err := NewFileDeadLetterStore(filepath.Join(t.TempDir(), "dlq.jsonl"))
+	if err != nil {
+		t.Fatalf("NewFileDeadLetterStore: %v", err)
+	}
+
+	entry := DeadLetterEntry{
+		ID:             "delivery-1",
+		URL:            "https://example.com/hook",
+		LastError:      "first failure",
+		Attempts:       1,
+		NextEligibleAt: time.Now().Add(-time.Minute),
+	}
+	if err := store.Put(entry); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	// Simulate a second failed drain of the same logical entry: the ID is
+	// unchanged, only the bookkeeping fields are updated.
+	entry.LastError = "second failure"
+	entry.Attempts = 2
+	entry.NextEligibleAt = time.Now().Add(time.Hour)
+	if err := store.Put(entry); err != nil {
+		t.Fatalf("Put (update): %v", err)
+	}
+
+	n, err := store.Len()
+	if err != nil {
+		t.Fatalf("Len: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("Len() = %d, want 1 (Put must upsert by ID, not append a duplicate)", n)
+	}
+
+	due, err := store.Due(time.Now())
+	if err != nil {
+		t.Fatalf("Due: %v", err)
+	}
+	if len(due) != 0 {
+		t.Fatalf("Due() returned %d entries, want 0: the updated NextEligibleAt is in the future", len(due))
+	}
+
+	due, err = store.Due(time.Now().Add(2 * time.Hour))
+	if err != nil {
+		t.Fatalf("Due: %v", err)
+	}
+	if len(due) != 1 || due[0].LastError != "second failure" {
+		t.Fatalf("Due() = %+v, want the single updated entry", due)
+	}
+}
@@ -0,0 +1,21 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// newID returns a random UUIDv4, used both for DeadLetterEntry IDs and, for
+// outgoing deliveries, the X-Gophish-Delivery header.
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand.Read only fails if the system CSPRNG is broken, which
+		// leaves little else to do but panic rather than hand out a
+		// predictable ID.
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
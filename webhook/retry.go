@@ -0,0 +1,77 @@
+package webhook
+
+import (
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy controls how DefaultSender retries a failed delivery before
+// giving up and writing the delivery to the DeadLetterStore.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of times a delivery will be attempted,
+	// including the initial try.
+	MaxAttempts int
+	// InitialBackoff is the backoff used after the first failed attempt.
+	InitialBackoff time.Duration
+	// Multiplier is applied to the backoff after every failed attempt.
+	Multiplier float64
+	// MaxBackoff caps the computed backoff before jitter is applied.
+	MaxBackoff time.Duration
+	// Jitter, when true, applies full jitter to the computed backoff
+	// (sleep = rand(0, min(MaxBackoff, InitialBackoff*Multiplier^attempt))).
+	Jitter bool
+	// Retryable decides whether a given response status code / error is
+	// worth retrying. statusCode is 0 when err is a network-level error.
+	Retryable func(statusCode int, err error) bool
+}
+
+// DefaultRetryPolicy returns the retry policy DefaultSender uses when none
+// is supplied: 5 attempts, 500ms initial backoff doubling up to 30s, with
+// full jitter, retrying network errors and 5xx/408/429 responses.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 500 * time.Millisecond,
+		Multiplier:     2,
+		MaxBackoff:     30 * time.Second,
+		Jitter:         true,
+		Retryable:      defaultRetryable,
+	}
+}
+
+// defaultRetryable retries network errors and 5xx/408/429 responses.
+func defaultRetryable(statusCode int, err error) bool {
+	if err != nil {
+		if _, ok := err.(net.Error); ok {
+			return true
+		}
+		return statusCode == 0
+	}
+	switch {
+	case statusCode == http.StatusRequestTimeout:
+		return true
+	case statusCode == http.StatusTooManyRequests:
+		return true
+	case statusCode >= 500:
+		return true
+	default:
+		return false
+	}
+}
+
+// backoff computes the delay before the given (zero-indexed) retry attempt,
+// per the policy's multiplier/cap, applying full jitter when configured.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt))
+	capped := d
+	if max := float64(p.MaxBackoff); p.MaxBackoff > 0 && capped > max {
+		capped = max
+	}
+	if !p.Jitter {
+		return time.Duration(capped)
+	}
+	return time.Duration(rand.Int63n(int64(capped) + 1))
+}
@@ -0,0 +1,126 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// TimestampHeader carries the unix-seconds time a delivery was signed at.
+	TimestampHeader = "X-Gophish-Timestamp"
+	// DeliveryHeader carries a UUID unique to this delivery attempt.
+	DeliveryHeader = "X-Gophish-Delivery"
+
+	signatureVersion = "v1"
+)
+
+var (
+	// ErrMissingSignatureHeaders is returned by Verify when any of the
+	// timestamp, delivery, or signature headers are absent.
+	ErrMissingSignatureHeaders = errors.New("webhook: missing signature headers")
+	// ErrTimestampOutOfTolerance is returned by Verify when the delivery's
+	// timestamp falls outside the caller's allowed tolerance window.
+	ErrTimestampOutOfTolerance = errors.New("webhook: timestamp outside of tolerance")
+	// ErrSignatureMismatch is returned by Verify when the computed signature
+	// doesn't match any of the provided secrets.
+	ErrSignatureMismatch = errors.New("webhook: signature mismatch")
+)
+
+// signValue computes the v1 signature for a single secret, covering the
+// delivery's timestamp and ID as well as the raw body, so neither can be
+// tampered with or replayed against a different delivery.
+func signValue(secret string, timestamp int64, deliveryID string, body []byte) (string, error) {
+	mac := hmac.New(sha256.New, []byte(secret))
+	if _, err := fmt.Fprintf(mac, "%s:%d:%s:", signatureVersion, timestamp, deliveryID); err != nil {
+		return "", err
+	}
+	if _, err := mac.Write(body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// signHeader builds the X-Gophish-Signature header value, emitting one
+// v1=<hex> pair per secret so a receiver mid-rotation can match against
+// either the current or previous secret.
+func signHeader(secrets []string, timestamp int64, deliveryID string, body []byte) (string, error) {
+	parts := make([]string, len(secrets))
+	for i, secret := range secrets {
+		sig, err := signValue(secret, timestamp, deliveryID, body)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = signatureVersion + "=" + sig
+	}
+	return strings.Join(parts, ","), nil
+}
+
+// parseSignatureHeader extracts the v1 signature values from a
+// X-Gophish-Signature header, ignoring any scheme it doesn't recognize.
+func parseSignatureHeader(header string) []string {
+	var sigs []string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 || kv[0] != signatureVersion {
+			continue
+		}
+		sigs = append(sigs, kv[1])
+	}
+	return sigs
+}
+
+// Verify validates an inbound webhook delivery against the given list of
+// active secrets (to support rotation) and a tolerance window for clock
+// skew/replay. On success it returns the delivery ID from the
+// X-Gophish-Delivery header so the caller can de-duplicate retried
+// deliveries.
+func Verify(r *http.Request, secrets []string, tolerance time.Duration) (string, error) {
+	tsHeader := r.Header.Get(TimestampHeader)
+	deliveryID := r.Header.Get(DeliveryHeader)
+	sigHeader := r.Header.Get(SignatureHeader)
+	if tsHeader == "" || deliveryID == "" || sigHeader == "" {
+		return "", ErrMissingSignatureHeaders
+	}
+
+	ts, err := strconv.ParseInt(tsHeader, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("webhook: invalid timestamp: %w", err)
+	}
+	if tolerance > 0 {
+		delivered := time.Unix(ts, 0)
+		now := time.Now()
+		if delivered.Before(now.Add(-tolerance)) || delivered.After(now.Add(tolerance)) {
+			return "", ErrTimestampOutOfTolerance
+		}
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return "", err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	candidates := parseSignatureHeader(sigHeader)
+	for _, secret := range secrets {
+		sig, err := signValue(secret, ts, deliveryID, body)
+		if err != nil {
+			return "", err
+		}
+		expected := []byte(sig)
+		for _, candidate := range candidates {
+			if hmac.Equal(expected, []byte(candidate)) {
+				return deliveryID, nil
+			}
+		}
+	}
+	return "", ErrSignatureMismatch
+}
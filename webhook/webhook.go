@@ -1,14 +1,15 @@
 package webhook
 
 import (
-  "crypto/hmac"
-  "crypto/sha256"
-  "encoding/hex"
+  "context"
   "net/http"
   "fmt"
   "errors"
   "encoding/json"
   "bytes"
+  "strconv"
+  "sync/atomic"
+  "time"
 
   log "github.com/gophish/gophish/logger"
 )
@@ -19,71 +20,367 @@ const (
   SignatureHeader = "X-Gophish-Signature"
 )
 
+// DefaultDLQPath is where FileDeadLetterStore writes failed deliveries when
+// NewDefaultSender is constructed without an explicit DeadLetterStore. It is
+// a package var so it can be pointed at the gophish data dir at startup.
+var DefaultDLQPath = "data/" + DefaultDLQFilename
+
+// DefaultDrainInterval is how often a DefaultSender re-attempts deliveries
+// sitting in its DeadLetterStore when none is supplied via WithDeadLetterStore.
+const DefaultDrainInterval = 5 * time.Minute
+
 //TODO
 
 type Sender interface {
-  Send(url string, secret string, data interface{}) error
+  Send(url string, secrets []string, data interface{}) error
+}
+
+// Stats reports point-in-time counters for a DefaultSender, suitable for
+// surfacing in the admin UI.
+type Stats struct {
+  Attempts  int64
+  Successes int64
+  DLQSize   int64
 }
 
 type DefaultSender struct {
-  client *http.Client
-} 
+  client        *http.Client
+  retryPolicy   RetryPolicy
+  dlq           DeadLetterStore
+  drainInterval time.Duration
+  stopDrain     chan struct{}
+  format        Format
+  source        string
+
+  attempts  int64
+  successes int64
+}
+
+// SenderOption configures a DefaultSender at construction time.
+type SenderOption func(*DefaultSender)
+
+// WithRetryPolicy overrides the retry policy used for every delivery.
+func WithRetryPolicy(policy RetryPolicy) SenderOption {
+  return func(ds *DefaultSender) {
+    ds.retryPolicy = policy
+  }
+}
 
-func NewDefaultSender() Sender {
-  a1 := DefaultSender{}
+// WithDeadLetterStore overrides where exhausted deliveries are recorded, and
+// how often they're re-attempted.
+func WithDeadLetterStore(store DeadLetterStore, drainInterval time.Duration) SenderOption {
+  return func(ds *DefaultSender) {
+    ds.dlq = store
+    ds.drainInterval = drainInterval
+  }
+}
+
+// WithFormat selects the wire format used for outgoing deliveries. The
+// default is FormatLegacy.
+func WithFormat(format Format) SenderOption {
+  return func(ds *DefaultSender) {
+    ds.format = format
+  }
+}
+
+// WithHTTPClient overrides the http.Client used for deliveries, e.g. to set
+// a shorter timeout than DefaultTimeoutSeconds.
+func WithHTTPClient(client *http.Client) SenderOption {
+  return func(ds *DefaultSender) {
+    ds.client = client
+  }
+}
+
+// WithSource sets the CloudEvents `source` attribute used in
+// FormatCloudEventsStructured/FormatCloudEventsBinary mode, e.g.
+// "gophish://phish.example.com/campaigns/42". It has no effect in
+// FormatLegacy mode.
+func WithSource(source string) SenderOption {
+  return func(ds *DefaultSender) {
+    ds.source = source
+  }
+}
+
+func NewDefaultSender(opts ...SenderOption) *DefaultSender {
+  a1 := &DefaultSender{
+    retryPolicy: DefaultRetryPolicy(),
+  }
   a2 := &http.Client{
-      Timeout: DefaultTimeoutSeconds,
+      Timeout: DefaultTimeoutSeconds * time.Second,
   }
   a1.client = a2
+
+  for _, opt := range opts {
+    opt(a1)
+  }
+
+  if a1.dlq == nil {
+    store, err := NewFileDeadLetterStore(DefaultDLQPath)
+    if err != nil {
+      log.Error(err)
+    } else {
+      a1.dlq = store
+    }
+  }
+  if a1.drainInterval <= 0 {
+    a1.drainInterval = DefaultDrainInterval
+  }
+
+  a1.stopDrain = make(chan struct{})
+  go a1.drainLoop()
+
   return a1
 }
 
+// Close stops the background DLQ drain goroutine. It is safe to call at
+// most once.
+func (ds *DefaultSender) Close() {
+  close(ds.stopDrain)
+}
+
+// Stats returns the current delivery counters, including the number of
+// deliveries currently parked in the DeadLetterStore.
+func (ds *DefaultSender) Stats() Stats {
+  stats := Stats{
+    Attempts:  atomic.LoadInt64(&ds.attempts),
+    Successes: atomic.LoadInt64(&ds.successes),
+  }
+  if ds.dlq != nil {
+    if n, err := ds.dlq.Len(); err == nil {
+      stats.DLQSize = int64(n)
+    }
+  }
+  return stats
+}
 
+func (ds *DefaultSender) drainLoop() {
+  ticker := time.NewTicker(ds.drainInterval)
+  defer ticker.Stop()
+  for {
+    select {
+    case <-ds.stopDrain:
+      return
+    case <-ticker.C:
+      ds.drainDeadLetters()
+    }
+  }
+}
 
+func (ds *DefaultSender) drainDeadLetters() {
+  if ds.dlq == nil {
+    return
+  }
+  entries, err := ds.dlq.Due(time.Now())
+  if err != nil {
+    log.Error(err)
+    return
+  }
+  for _, entry := range entries {
+    // Use deliver, not attempt: attempt would write its own fresh
+    // DeadLetterEntry on failure, leaving this entry's stale copy behind
+    // too. We own updating/removing this entry ourselves below.
+    var data interface{} = json.RawMessage(entry.Payload)
+    if entry.CloudEventType != "" {
+      // Re-wrap so a CloudEvents-format redrive still carries the
+      // original type/subject instead of reverting to the defaults.
+      data = deadLetterCloudEvent{
+        raw:     json.RawMessage(entry.Payload),
+        evtType: entry.CloudEventType,
+        subject: entry.CloudEventSubject,
+      }
+    }
+    err := ds.deliver(context.Background(), entry.URL, entry.Secrets, data)
+    if err == nil {
+      if err := ds.dlq.Remove(entry.ID); err != nil {
+        log.Error(err)
+      }
+      continue
+    }
+    entry.LastError = err.Error()
+    entry.Attempts++
+    entry.NextEligibleAt = time.Now().Add(ds.drainInterval)
+    if err := ds.dlq.Put(entry); err != nil {
+      log.Error(err)
+    }
+  }
+}
 
+func (ds *DefaultSender) Send(url string, secrets []string, data interface{}) error {
+  return ds.attempt(context.Background(), url, secrets, data)
+}
 
+// SendContext behaves like Send, but aborts (without writing to the
+// DeadLetterStore) as soon as ctx is done, so a caller can cancel
+// in-flight deliveries when, e.g., a campaign is aborted.
+func (ds *DefaultSender) SendContext(ctx context.Context, url string, secrets []string, data interface{}) error {
+  return ds.attempt(ctx, url, secrets, data)
+}
 
+// attempt performs a single delivery with retries per ds.retryPolicy,
+// writing to the DeadLetterStore whenever the delivery fails and ctx is not
+// done. That includes failures the policy does not retry (e.g. a 4xx
+// response), which are recorded after their single attempt. Callers that
+// maintain their own DeadLetterEntry (i.e. drainDeadLetters) should call
+// deliver directly instead, to avoid ending up with two DLQ entries for
+// the same logical failure.
+func (ds *DefaultSender) attempt(ctx context.Context, url string, secrets []string, data interface{}) error {
+  err := ds.deliver(ctx, url, secrets, data)
+  if err != nil && ctx.Err() == nil {
+    ds.deadLetter(url, secrets, data, err)
+  }
+  return err
+}
 
+// deliver performs a single delivery with retries per ds.retryPolicy, but
+// never touches the DeadLetterStore - that's the caller's responsibility.
+// The same delivery ID is reused across every retry of a given delivery,
+// since a retry isn't a new logical event.
+func (ds *DefaultSender) deliver(ctx context.Context, url string, secrets []string, data interface{}) error {
+  policy := ds.retryPolicy
+  maxAttempts := policy.MaxAttempts
+  if maxAttempts <= 0 {
+    maxAttempts = 1
+  }
+  deliveryID := newID()
 
+  var lastErr error
+  var lastResp *http.Response
+  for i := 0; i < maxAttempts; i++ {
+    atomic.AddInt64(&ds.attempts, 1)
+    resp, err := ds.do(ctx, url, secrets, deliveryID, data)
+    if err == nil {
+      atomic.AddInt64(&ds.successes, 1)
+      resp.Body.Close()
+      return nil
+    }
+    lastErr = err
+    lastResp = resp
 
+    if ctx.Err() != nil {
+      return ctx.Err()
+    }
 
+    // Only treat err as a transport-level failure once resp is nil; when a
+    // response was received, the status code (not the synthetic "http
+    // status of response" error do() returns for it) is what should drive
+    // the retry decision.
+    statusCode := 0
+    retryErr := err
+    if resp != nil {
+      statusCode = resp.StatusCode
+      resp.Body.Close()
+      retryErr = nil
+    }
+    retryable := policy.Retryable != nil && policy.Retryable(statusCode, retryErr)
+    if !retryable || i == maxAttempts-1 {
+      break
+    }
+    wait := retryAfter(lastResp)
+    if wait <= 0 {
+      wait = policy.backoff(i)
+    }
+    select {
+    case <-ctx.Done():
+      return ctx.Err()
+    case <-time.After(wait):
+    }
+  }
 
+  return lastErr
+}
 
-func (ds DefaultSender) Send(url string, secret string, data interface{}) error {
-  jsonData, err := json.Marshal(data)
+// do performs a single HTTP delivery attempt, signing the body with the
+// v1 timestamped scheme under the given delivery ID.
+func (ds DefaultSender) do(ctx context.Context, url string, secrets []string, deliveryID string, data interface{}) (*http.Response, error) {
+  body, contentType, ceHeaders, err := ds.buildBody(deliveryID, data)
+  if err != nil {
+    log.Error(err)
+    return nil, err
+  }
+  req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
   if err != nil {
     log.Error(err)
-    return err
+    return nil, err
+  }
+  timestamp := time.Now().Unix()
+  signat, err := signHeader(secrets, timestamp, deliveryID, body)
+  if err != nil {
+    log.Error(err)
+    return nil, err
   }
-  req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-  signat, err := sign(secret, data)
   req.Header.Set(SignatureHeader, signat)
-  req.Header.Set("Content-Type", "application/json")
+  req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+  req.Header.Set(DeliveryHeader, deliveryID)
+  req.Header.Set("Content-Type", contentType)
+  for k, v := range ceHeaders {
+    req.Header.Set(k, v)
+  }
   resp, err := ds.client.Do(req)
   if err != nil {
     log.Error(err)
-    return err
+    return nil, err
   }
-  defer resp.Body.Close()
-
 
-  //TODO
   if resp.StatusCode >= MinHttpStatusErrorCode {
     errMsg := fmt.Sprintf("http status of response: %s", resp.Status)
     log.Error(errMsg)
-    return errors.New(errMsg)
+    return resp, errors.New(errMsg)
   }
-  return nil
+  return resp, nil
 }
 
-func sign(secret string, data []byte) (string, error) {
-  hash1 := hmac.New(sha256.New, []byte(secret))
-  _, err := hash1.Write(data)
+// deadLetter records an exhausted delivery so it can be re-attempted by the
+// drain loop.
+func (ds *DefaultSender) deadLetter(url string, secrets []string, data interface{}, lastErr error) {
+  if ds.dlq == nil || lastErr == nil {
+    return
+  }
+  payload, err := json.Marshal(data)
   if err != nil {
-    return "", err
+    log.Error(err)
+    return
+  }
+  entry := DeadLetterEntry{
+    ID:             newID(),
+    URL:            url,
+    Secrets:        secrets,
+    Payload:        payload,
+    Headers:        http.Header{"Content-Type": []string{"application/json"}},
+    LastError:      lastErr.Error(),
+    Attempts:       ds.retryPolicy.MaxAttempts,
+    NextEligibleAt: time.Now().Add(ds.drainInterval),
+  }
+  if ds.format == FormatCloudEventsStructured || ds.format == FormatCloudEventsBinary {
+    entry.CloudEventType = DefaultCloudEventType
+    if meta, ok := data.(CloudEventMetadata); ok {
+      entry.CloudEventType = meta.CloudEventType()
+      entry.CloudEventSubject = meta.CloudEventSubject()
+    }
+  }
+  if err := ds.dlq.Put(entry); err != nil {
+    log.Error(err)
+  }
+}
+
+// retryAfter honors a Retry-After response header, expressed either as a
+// number of seconds or an HTTP-date. It returns 0 when absent or unparsable.
+func retryAfter(resp *http.Response) time.Duration {
+  if resp == nil {
+    return 0
+  }
+  v := resp.Header.Get("Retry-After")
+  if v == "" {
+    return 0
+  }
+  if secs, err := strconv.Atoi(v); err == nil {
+    return time.Duration(secs) * time.Second
+  }
+  if t, err := http.ParseTime(v); err == nil {
+    if d := time.Until(t); d > 0 {
+      return d
+    }
   }
-  hexStr := hex.EncodeToString(hash1.Sum(nil))
-  return hexStr, nil
+  return 0
 }
 
 // func interfaceToBytes(data interface{}) ([]byte, error) {
@@ -0,0 +1,346 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testPayload struct {
+	Message string `json:"message"`
+}
+
+func newTestSender(t *testing.T, opts ...SenderOption) *DefaultSender {
+	t.Helper()
+	store, err := NewFileDeadLetterStore(filepath.Join(t.TempDir(), "dlq.jsonl"))
+	if err != nil {
+		t.Fatalf("NewFileDeadLetterStore: %v", err)
+	}
+	base := []SenderOption{
+		WithDeadLetterStore(store, time.Hour),
+		WithRetryPolicy(RetryPolicy{
+			MaxAttempts:    1,
+			InitialBackoff: time.Millisecond,
+			Multiplier:     2,
+			MaxBackoff:     10 * time.Millisecond,
+			Retryable:      defaultRetryable,
+		}),
+	}
+	ds := NewDefaultSender(append(base, opts...)...)
+	t.Cleanup(ds.Close)
+	return ds
+}
+
+func TestSendSuccess(t *testing.T) {
+	var gotBody []byte
+	var gotHeaders http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = ioutil.ReadAll(r.Body)
+		gotHeaders = r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	ds := newTestSender(t)
+	if err := ds.Send(srv.URL, []string{"secret"}, testPayload{Message: "hi"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	want := `{"message":"hi"}`
+	if string(gotBody) != want {
+		t.Errorf("got body %q, want %q", gotBody, want)
+	}
+
+	r := &http.Request{Header: gotHeaders, Body: ioutil.NopCloser(bytes.NewReader(gotBody))}
+	deliveryID, err := Verify(r, []string{"secret"}, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if deliveryID != gotHeaders.Get(DeliveryHeader) {
+		t.Errorf("Verify returned delivery ID %q, want %q", deliveryID, gotHeaders.Get(DeliveryHeader))
+	}
+
+	if stats := ds.Stats(); stats.Successes != 1 {
+		t.Errorf("Stats().Successes = %d, want 1", stats.Successes)
+	}
+}
+
+func TestSendSignatureRotation(t *testing.T) {
+	var gotHeaders http.Header
+	var gotBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = ioutil.ReadAll(r.Body)
+		gotHeaders = r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	ds := newTestSender(t)
+	// "new" is the active secret, "old" is being rotated out. A receiver that
+	// still only trusts "old" should be able to verify the delivery.
+	if err := ds.Send(srv.URL, []string{"new", "old"}, testPayload{Message: "hi"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	r := &http.Request{Header: gotHeaders, Body: ioutil.NopCloser(bytes.NewReader(gotBody))}
+	if _, err := Verify(r, []string{"old"}, 5*time.Minute); err != nil {
+		t.Errorf("Verify with rotated-out secret: %v", err)
+	}
+
+	r = &http.Request{Header: gotHeaders, Body: ioutil.NopCloser(bytes.NewReader(gotBody))}
+	if _, err := Verify(r, []string{"wrong"}, 5*time.Minute); err != ErrSignatureMismatch {
+		t.Errorf("Verify with wrong secret: got %v, want ErrSignatureMismatch", err)
+	}
+}
+
+func Test4xxIsNotRetried(t *testing.T) {
+	var requests int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	ds := newTestSender(t, WithRetryPolicy(RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		Multiplier:     2,
+		MaxBackoff:     10 * time.Millisecond,
+		Retryable:      defaultRetryable,
+	}))
+	if err := ds.Send(srv.URL, []string{"secret"}, testPayload{Message: "hi"}); err == nil {
+		t.Fatal("Send: expected error for 400 response, got nil")
+	}
+	if requests != 1 {
+		t.Errorf("got %d requests, want 1 (400s should not be retried)", requests)
+	}
+}
+
+func Test5xxIsRetriedThenDeadLettered(t *testing.T) {
+	var requests int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	ds := newTestSender(t, WithRetryPolicy(RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		Multiplier:     2,
+		MaxBackoff:     10 * time.Millisecond,
+		Retryable:      defaultRetryable,
+	}))
+	if err := ds.Send(srv.URL, []string{"secret"}, testPayload{Message: "hi"}); err == nil {
+		t.Fatal("Send: expected error for persistent 500 response, got nil")
+	}
+	if requests != 3 {
+		t.Errorf("got %d requests, want 3", requests)
+	}
+	if stats := ds.Stats(); stats.DLQSize != 1 {
+		t.Errorf("Stats().DLQSize = %d, want 1", stats.DLQSize)
+	}
+}
+
+func TestSendTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	ds := newTestSender(t, WithHTTPClient(&http.Client{Timeout: 20 * time.Millisecond}))
+	start := time.Now()
+	err := ds.Send(srv.URL, []string{"secret"}, testPayload{Message: "hi"})
+	if err == nil {
+		t.Fatal("Send: expected a timeout error, got nil")
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("Send took %s, expected it to time out well under the server's 200ms delay", elapsed)
+	}
+}
+
+// testCloudEvent implements CloudEventMetadata so the structured/binary
+// tests can assert that `type`/`subject` come from the payload rather than
+// falling back to DefaultCloudEventType.
+type testCloudEvent struct {
+	Message string `json:"message"`
+}
+
+func (e testCloudEvent) CloudEventType() string    { return "com.gophish.test.event" }
+func (e testCloudEvent) CloudEventSubject() string { return "subject-123" }
+
+func TestSendCloudEventsStructured(t *testing.T) {
+	var gotBody []byte
+	var gotHeaders http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = ioutil.ReadAll(r.Body)
+		gotHeaders = r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	ds := newTestSender(t, WithFormat(FormatCloudEventsStructured), WithSource("gophish://test"))
+	if err := ds.Send(srv.URL, []string{"secret"}, testCloudEvent{Message: "hi"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	if ct := gotHeaders.Get("Content-Type"); ct != "application/cloudevents+json" {
+		t.Errorf("Content-Type = %q, want application/cloudevents+json", ct)
+	}
+
+	var evt cloudEvent
+	if err := json.Unmarshal(gotBody, &evt); err != nil {
+		t.Fatalf("unmarshal envelope: %v", err)
+	}
+	if evt.SpecVersion != cloudEventsSpecVersion {
+		t.Errorf("specversion = %q, want %q", evt.SpecVersion, cloudEventsSpecVersion)
+	}
+	if evt.Source != "gophish://test" {
+		t.Errorf("source = %q, want gophish://test", evt.Source)
+	}
+	if evt.Type != "com.gophish.test.event" {
+		t.Errorf("type = %q, want com.gophish.test.event", evt.Type)
+	}
+	if evt.Subject != "subject-123" {
+		t.Errorf("subject = %q, want subject-123", evt.Subject)
+	}
+	if evt.ID == "" || evt.ID != gotHeaders.Get(DeliveryHeader) {
+		t.Errorf("id = %q, want it to match the %s header %q", evt.ID, DeliveryHeader, gotHeaders.Get(DeliveryHeader))
+	}
+	if want := `{"message":"hi"}`; string(evt.Data) != want {
+		t.Errorf("data = %s, want %s", evt.Data, want)
+	}
+
+	// The signature must cover the final wire body - the whole CloudEvents
+	// envelope, not just the inner payload.
+	r := &http.Request{Header: gotHeaders, Body: ioutil.NopCloser(bytes.NewReader(gotBody))}
+	if _, err := Verify(r, []string{"secret"}, 5*time.Minute); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+func TestSendCloudEventsBinary(t *testing.T) {
+	var gotBody []byte
+	var gotHeaders http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = ioutil.ReadAll(r.Body)
+		gotHeaders = r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	ds := newTestSender(t, WithFormat(FormatCloudEventsBinary), WithSource("gophish://test"))
+	if err := ds.Send(srv.URL, []string{"secret"}, testCloudEvent{Message: "hi"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	if ct := gotHeaders.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	if want := `{"message":"hi"}`; string(gotBody) != want {
+		t.Errorf("body = %s, want the raw payload %s", gotBody, want)
+	}
+
+	wantHeaders := map[string]string{
+		"ce-specversion": cloudEventsSpecVersion,
+		"ce-source":      "gophish://test",
+		"ce-type":        "com.gophish.test.event",
+		"ce-subject":     "subject-123",
+	}
+	for k, want := range wantHeaders {
+		if got := gotHeaders.Get(k); got != want {
+			t.Errorf("%s = %q, want %q", k, got, want)
+		}
+	}
+	if id := gotHeaders.Get("ce-id"); id == "" || id != gotHeaders.Get(DeliveryHeader) {
+		t.Errorf("ce-id = %q, want it to match the %s header %q", id, DeliveryHeader, gotHeaders.Get(DeliveryHeader))
+	}
+
+	// The signature must cover the raw payload actually sent over the wire
+	// in binary mode, not the (never-sent) structured envelope.
+	r := &http.Request{Header: gotHeaders, Body: ioutil.NopCloser(bytes.NewReader(gotBody))}
+	if _, err := Verify(r, []string{"secret"}, 5*time.Minute); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+// TestDrainDeadLettersPreservesCloudEventType proves that a CloudEvents-format
+// delivery redriven out of the DeadLetterStore keeps its original `type`/
+// `subject`, rather than reverting to DefaultCloudEventType/empty because the
+// replayed payload is a bare json.RawMessage.
+func TestDrainDeadLettersPreservesCloudEventType(t *testing.T) {
+	var requests int64
+	var gotType, gotSubject string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&requests, 1)
+		if n == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var evt cloudEvent
+		json.NewDecoder(r.Body).Decode(&evt)
+		gotType = evt.Type
+		gotSubject = evt.Subject
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	ds := newTestSender(t, WithFormat(FormatCloudEventsStructured), WithSource("gophish://test"))
+	if err := ds.Send(srv.URL, []string{"secret"}, testCloudEvent{Message: "hi"}); err == nil {
+		t.Fatal("Send: expected the first (500) attempt to fail, got nil")
+	}
+	if stats := ds.Stats(); stats.DLQSize != 1 {
+		t.Fatalf("Stats().DLQSize = %d, want 1", stats.DLQSize)
+	}
+
+	// deadLetter parks the entry until one drain interval (an hour here) from
+	// now; make it due so the drain below picks it up.
+	parked, err := ds.dlq.Due(time.Now().Add(2 * time.Hour))
+	if err != nil || len(parked) != 1 {
+		t.Fatalf("Due() = %d entries, err %v; want the single parked entry", len(parked), err)
+	}
+	parked[0].NextEligibleAt = time.Now()
+	if err := ds.dlq.Put(parked[0]); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	ds.drainDeadLetters()
+
+	if gotType != "com.gophish.test.event" {
+		t.Errorf("redriven type = %q, want com.gophish.test.event", gotType)
+	}
+	if gotSubject != "subject-123" {
+		t.Errorf("redriven subject = %q, want subject-123", gotSubject)
+	}
+	if stats := ds.Stats(); stats.DLQSize != 0 {
+		t.Errorf("Stats().DLQSize = %d, want 0 after a successful redrive", stats.DLQSize)
+	}
+}
+
+func TestSendContextCancellation(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	ds := newTestSender(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	err := ds.SendContext(ctx, srv.URL, []string{"secret"}, testPayload{Message: "hi"})
+	if err == nil {
+		t.Fatal("SendContext: expected an error from the cancelled context, got nil")
+	}
+}